@@ -0,0 +1,317 @@
+package trigram
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// BuilderOptions configures a Builder.
+type BuilderOptions struct {
+	// Shards is the number of internal maps documents' trigrams are
+	// spread across, keyed by trigram value modulo Shards, so extraction
+	// workers contend on different locks instead of one shared map.
+	// Defaults to runtime.GOMAXPROCS(0) if zero.
+	Shards int
+
+	// MaxPostingMemory caps the approximate number of (trigram, DocID)
+	// postings held in memory before the Builder spills its shards to
+	// temporary files as varint-delta runs, freeing the maps so indexing
+	// a corpus much larger than RAM doesn't require holding every
+	// posting list at once. Zero means no limit.
+	MaxPostingMemory int64
+}
+
+// Builder incrementally builds an Index, extracting trigrams for
+// documents added via Add across a pool of worker goroutines and
+// merging everything together in Finish. It's the streaming counterpart
+// to NewIndex, for corpora too large, or too slow, to index in one
+// single-threaded pass.
+type Builder struct {
+	opts BuilderOptions
+
+	nextID   uint32
+	postings int64 // approx postings held in memory; adjusted with atomic ops
+
+	mu     []sync.Mutex
+	shards []map[T][]DocID
+
+	spillMu sync.Mutex
+	spills  [][]string // spilled files per shard, oldest first
+
+	jobs chan builderJob
+	wg   sync.WaitGroup
+}
+
+type builderJob struct {
+	id DocID
+	s  string
+}
+
+// NewBuilder returns a Builder ready to accept documents via Add.
+func NewBuilder(opts BuilderOptions) *Builder {
+	if opts.Shards == 0 {
+		opts.Shards = runtime.GOMAXPROCS(0)
+	}
+	if opts.Shards < 1 {
+		opts.Shards = 1
+	}
+
+	b := &Builder{
+		opts:   opts,
+		mu:     make([]sync.Mutex, opts.Shards),
+		shards: make([]map[T][]DocID, opts.Shards),
+		spills: make([][]string, opts.Shards),
+		jobs:   make(chan builderJob, opts.Shards*4),
+	}
+	for i := range b.shards {
+		b.shards[i] = make(map[T][]DocID)
+	}
+
+	b.wg.Add(opts.Shards)
+	for i := 0; i < opts.Shards; i++ {
+		go b.work()
+	}
+
+	return b
+}
+
+func (b *Builder) work() {
+	defer b.wg.Done()
+	for job := range b.jobs {
+		ts := ExtractAll(job.s, nil)
+		b.insert(job.id, ts)
+	}
+}
+
+func (b *Builder) shardOf(t T) int {
+	return int(uint32(t) % uint32(len(b.shards)))
+}
+
+func (b *Builder) insert(id DocID, ts []T) {
+	byShard := make(map[int][]T)
+	for _, t := range ts {
+		s := b.shardOf(t)
+		byShard[s] = append(byShard[s], t)
+	}
+
+	for s, tris := range byShard {
+		b.mu[s].Lock()
+		m := b.shards[s]
+		for _, t := range tris {
+			l := m[t]
+			ln := len(l)
+			if ln == 0 || l[ln-1] != id {
+				m[t] = append(l, id)
+				atomic.AddInt64(&b.postings, 1)
+			}
+		}
+		b.mu[s].Unlock()
+	}
+
+	if b.opts.MaxPostingMemory > 0 && atomic.LoadInt64(&b.postings) > b.opts.MaxPostingMemory {
+		b.spill()
+	}
+}
+
+// Add assigns s the next DocID and queues it onto the worker pool, which
+// extracts its trigrams and inserts them. The returned ID is valid
+// immediately; s itself is only extracted and indexed asynchronously,
+// fanning that work out across the pool instead of paying for it in the
+// caller's goroutine.
+func (b *Builder) Add(s string) DocID {
+	id := DocID(atomic.AddUint32(&b.nextID, 1) - 1)
+	b.jobs <- builderJob{id, s}
+	return id
+}
+
+// spill writes every non-empty shard's current postings to a temporary
+// file and clears the in-memory maps. Spill files use their own simple
+// sequential format (see writeSpill/readSpill) rather than the on-disk
+// Index format WriteTo produces: a shard map is a fragment of an index,
+// not a deliverable one - it has no tAllDocIDs to remap DocIDs against,
+// and its trigrams only need to be read back in bulk at Finish, never
+// binary-searched, so there's no offset table or trailer to maintain.
+func (b *Builder) spill() {
+	for s := range b.shards {
+		b.mu[s].Lock()
+		m := b.shards[s]
+		if len(m) == 0 {
+			b.mu[s].Unlock()
+			continue
+		}
+
+		f, err := ioutil.TempFile("", "go-trigram-spill-")
+		if err != nil {
+			// best effort: keep building in memory if we can't spill
+			b.mu[s].Unlock()
+			continue
+		}
+
+		err = writeSpill(f, m)
+		f.Close()
+		if err != nil {
+			// best effort: keep building in memory if the spill failed
+			os.Remove(f.Name())
+			b.mu[s].Unlock()
+			continue
+		}
+
+		atomic.AddInt64(&b.postings, -int64(countPostings(m)))
+		b.shards[s] = make(map[T][]DocID)
+
+		b.spillMu.Lock()
+		b.spills[s] = append(b.spills[s], f.Name())
+		b.spillMu.Unlock()
+
+		b.mu[s].Unlock()
+	}
+}
+
+func countPostings(m map[T][]DocID) int {
+	var n int
+	for _, v := range m {
+		n += len(v)
+	}
+	return n
+}
+
+// writeSpill writes m to w as: a varint count of trigrams, then for each
+// trigram its 3-byte encoding followed by its DocIDs varint-delta-encoded
+// and terminated by a zero delta, the same delta encoding WriteTo uses
+// for posting lists. Unlike WriteTo, DocIDs are written as-is - a spilled
+// shard has no notion of a dense 0..numDocs-1 range to remap them into.
+func writeSpill(w io.Writer, m map[T][]DocID) error {
+	bw := bufio.NewWriter(w)
+	var buf [binary.MaxVarintLen64]byte
+
+	n := binary.PutUvarint(buf[:], uint64(len(m)))
+	if _, err := bw.Write(buf[:n]); err != nil {
+		return err
+	}
+
+	for t, ids := range m {
+		var tb [3]byte
+		tb[0], tb[1], tb[2] = byte(t>>16), byte(t>>8), byte(t)
+		if _, err := bw.Write(tb[:]); err != nil {
+			return err
+		}
+
+		prev := int64(-1)
+		for _, id := range ids {
+			n := binary.PutUvarint(buf[:], uint64(int64(id)-prev))
+			prev = int64(id)
+			if _, err := bw.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		n := binary.PutUvarint(buf[:], 0)
+		if _, err := bw.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// readSpill reads a file written by writeSpill back into a shard map.
+func readSpill(path string) (map[T][]DocID, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	count, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, ErrCorrupt
+	}
+	data = data[n:]
+
+	m := make(map[T][]DocID, count)
+	for i := uint64(0); i < count; i++ {
+		if len(data) < 3 {
+			return nil, ErrCorrupt
+		}
+		t := T(uint32(data[0])<<16 | uint32(data[1])<<8 | uint32(data[2]))
+		data = data[3:]
+
+		var ids []DocID
+		prev := int64(-1)
+		for {
+			delta, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil, ErrCorrupt
+			}
+			data = data[n:]
+			if delta == 0 {
+				break
+			}
+			prev += int64(delta)
+			ids = append(ids, DocID(prev))
+		}
+		m[t] = ids
+	}
+
+	return m, nil
+}
+
+// mergeInto appends src's postings onto idx. Finish sorts the result
+// once everything has been merged, so the order in which shards and
+// spills are folded in here doesn't matter.
+func mergeInto(idx, src Index) {
+	for t, ids := range src {
+		if t != tAllDocIDs && t != tTombstones {
+			idx[t] = append(idx[t], ids...)
+		}
+	}
+}
+
+// Finish waits for all queued documents to finish indexing, merges any
+// spilled shards back in, and returns the completed Index. Merging reads
+// and accumulates one spilled shard file at a time rather than ever
+// opening all of them at once, but the resulting Index is still built up
+// entirely in memory - MaxPostingMemory only bounds the working set
+// while documents are being added, not the size of the final Index
+// Finish returns. The Builder must not be used afterwards.
+func (b *Builder) Finish() Index {
+	close(b.jobs)
+	b.wg.Wait()
+
+	idx := make(Index)
+
+	for s := range b.shards {
+		for _, path := range b.spills[s] {
+			if spilled, err := readSpill(path); err == nil {
+				mergeInto(idx, Index(spilled))
+			}
+			os.Remove(path)
+		}
+		mergeInto(idx, Index(b.shards[s]))
+	}
+
+	allDocIDs := make([]DocID, b.nextID)
+	for i := range allDocIDs {
+		allDocIDs[i] = DocID(i)
+	}
+	idx[tAllDocIDs] = allDocIDs
+
+	idx.Sort()
+
+	return idx
+}
+
+// NewIndex returns an index for the strings in docs. It's a thin wrapper
+// over Builder for the common case of indexing an in-memory slice of
+// documents in one call.
+func NewIndex(docs []string) Index {
+	b := NewBuilder(BuilderOptions{})
+	for _, d := range docs {
+		b.Add(d)
+	}
+	return b.Finish()
+}
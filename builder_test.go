@@ -0,0 +1,100 @@
+package trigram
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestBuilderAddExtractsInWorkers(t *testing.T) {
+	b := NewBuilder(BuilderOptions{Shards: 4})
+
+	docs := make([]string, 200)
+	for i := range docs {
+		docs[i] = fmt.Sprintf("document number %d has some unique content", i)
+	}
+
+	ids := make([]DocID, len(docs))
+	for i, d := range docs {
+		ids[i] = b.Add(d)
+	}
+	idx := b.Finish()
+
+	for i, d := range docs {
+		got := idx.Query(d)
+		found := false
+		for _, id := range got {
+			if id == ids[i] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Query(%q) = %v, missing its own DocID %d", d, got, ids[i])
+		}
+	}
+}
+
+func TestBuilderMatchesNewIndex(t *testing.T) {
+	docs := []string{"foo", "bar", "foobar", "quux", "foobarbaz"}
+
+	want := NewIndex(docs)
+
+	b := NewBuilder(BuilderOptions{Shards: 3})
+	for _, d := range docs {
+		b.Add(d)
+	}
+	got := b.Finish()
+
+	for _, q := range []string{"foo", "bar", "baz", "oob"} {
+		w, g := want.Query(q), got.Query(q)
+		if !equalDocIDs(sortedCopy(w), sortedCopy(g)) {
+			t.Errorf("Query(%q) = %v, want %v", q, g, w)
+		}
+	}
+}
+
+func TestBuilderSpillsAndMerges(t *testing.T) {
+	b := NewBuilder(BuilderOptions{Shards: 2, MaxPostingMemory: 20})
+
+	docs := make([]string, 50)
+	for i := range docs {
+		docs[i] = fmt.Sprintf("document number %d has some unique content", i)
+	}
+
+	ids := make([]DocID, len(docs))
+	for i, d := range docs {
+		ids[i] = b.Add(d)
+	}
+	idx := b.Finish()
+
+	var spilled bool
+	for _, paths := range b.spills {
+		if len(paths) > 0 {
+			spilled = true
+		}
+	}
+	if !spilled {
+		t.Fatal("no shard spilled; test doesn't exercise the spill+merge path")
+	}
+
+	for i, d := range docs {
+		got := idx.Query(d)
+		found := false
+		for _, id := range got {
+			if id == ids[i] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Query(%q) = %v, missing its own DocID %d", d, got, ids[i])
+		}
+	}
+}
+
+func sortedCopy(ids []DocID) []DocID {
+	out := append([]DocID(nil), ids...)
+	sort.Sort(docList(out))
+	return out
+}
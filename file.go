@@ -0,0 +1,487 @@
+package trigram
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"syscall"
+)
+
+// fileMagic identifies a trigram index file. It is written at the start
+// and end of the file so a reader can verify it opened the right thing
+// from either direction.
+const fileMagic = "go-trigram1"
+
+// trailerLen is the size in bytes of the fixed-size trailer: the names
+// section's byte offset (0 if absent), the offset table's byte offset,
+// the number of entries in it, and the magic.
+const trailerLen = 8 + 8 + 8 + len(fileMagic)
+
+// trigramRecordLen is the size in bytes of one entry in the offset table:
+// a 3-byte trigram followed by an 8-byte big-endian posting list offset.
+const trigramRecordLen = 3 + 8
+
+// ErrCorrupt is returned by Open and Load when a file does not look like
+// a trigram index, or is truncated.
+var ErrCorrupt = errors.New("trigram: corrupt index file")
+
+// WriteTo writes idx in a compact on-disk format: a header giving the
+// total document count, one posting list per trigram (sorted by trigram
+// value, each encoded as the trigram followed by varint-encoded deltas
+// between successive DocIDs and terminated by a zero delta), an offset
+// table mapping each trigram to the byte offset of its posting list, and
+// a trailer pointing at the offset table. Deltas keep the file compact
+// and make posting lists implicitly sorted. The offset table lets Open
+// binary-search straight to a trigram's postings without scanning the
+// file. It does not persist document names; use WriteToNames for that.
+//
+// Any documents deleted via DeleteID but not yet Compacted are physically
+// dropped from the written file, the same as if Compact had been called
+// first - a tombstone only has meaning against the in-memory Index that
+// recorded it, and would otherwise silently vanish on the next round trip
+// through Open/Load. Surviving DocIDs are renumbered to the dense
+// 0..numDocs-1 range Open/Load expect, so gaps left behind by deletions
+// don't leak into the file.
+func (idx Index) WriteTo(w io.Writer) (int64, error) {
+	return idx.WriteToNames(w, nil)
+}
+
+// WriteToNames writes idx in the same on-disk format as WriteTo, plus a
+// names section holding names, a list of document names (or arbitrary
+// per-document metadata) indexed by DocID as idx itself knows it, i.e.
+// names[id] names the document originally assigned DocID id, whether or
+// not it survives any pending Compact. names may be nil to omit the
+// section entirely, as WriteTo does. ReadOnlyIndex.Name recovers an entry
+// after a round trip through Open, renumbered the same way the posting
+// lists are.
+//
+// idx is left untouched until the write has fully succeeded: WriteToNames
+// computes what Compact would produce without mutating idx, and only
+// calls idx.Compact for real once every byte has been written and
+// flushed. That way a failure partway through (disk full, a broken pipe,
+// ...) never leaves idx with its tombstoned postings dropped and no
+// valid file to show for it.
+func (idx Index) WriteToNames(w io.Writer, names []string) (int64, error) {
+	tomb := idx[tTombstones]
+
+	// The file format identifies documents by a dense 0..numDocs-1 range
+	// (Open and Load both reconstruct tAllDocIDs that way), but tombstoned
+	// documents leave gaps in that range, so remap every surviving ID to
+	// its position in the sorted survivor list before writing anything
+	// else.
+	survivors := idx[tAllDocIDs]
+	if len(tomb) > 0 {
+		survivors = removeSorted(append([]DocID(nil), survivors...), tomb)
+	}
+	remap := make(map[DocID]DocID, len(survivors))
+	for newID, oldID := range survivors {
+		remap[oldID] = DocID(newID)
+	}
+	if names != nil {
+		remapped := make([]string, len(survivors))
+		for newID, oldID := range survivors {
+			if int(oldID) < len(names) {
+				remapped[newID] = names[oldID]
+			}
+		}
+		names = remapped
+	}
+
+	// Mirror Compact's view of every trigram's posting list - tombstoned
+	// IDs dropped, and any trigram left with none of its documents
+	// omitted entirely (the same "empty means deleted, not pruned"
+	// invariant Compact itself preserves) - without mutating idx.
+	postings := make(map[T][]DocID, len(idx))
+	for t, ids := range idx {
+		if t == tAllDocIDs || t == tTombstones {
+			continue
+		}
+		if ids == nil {
+			// pruned via Prune: kept, written as an empty posting list,
+			// which Load decodes back to nil
+			postings[t] = nil
+			continue
+		}
+		if len(tomb) > 0 {
+			ids = removeSorted(append([]DocID(nil), ids...), tomb)
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		postings[t] = ids
+	}
+
+	bw := bufio.NewWriter(w)
+	var written int64
+
+	n, err := bw.WriteString(fileMagic)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	var buf [binary.MaxVarintLen64]byte
+	m := binary.PutUvarint(buf[:], uint64(len(survivors)))
+	n, err = bw.Write(buf[:m])
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	var namesOffset int64
+	if names != nil {
+		namesOffset = written
+
+		m := binary.PutUvarint(buf[:], uint64(len(names)))
+		n, err := bw.Write(buf[:m])
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+
+		for _, name := range names {
+			nb := []byte(name)
+			m := binary.PutUvarint(buf[:], uint64(len(nb)))
+			n, err := bw.Write(buf[:m])
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+			n, err = bw.Write(nb)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+
+	trigrams := make([]T, 0, len(postings))
+	for t := range postings {
+		trigrams = append(trigrams, t)
+	}
+	sort.Slice(trigrams, func(i, j int) bool { return trigrams[i] < trigrams[j] })
+
+	type tableEntry struct {
+		t      T
+		offset int64
+	}
+	table := make([]tableEntry, 0, len(trigrams))
+
+	for _, t := range trigrams {
+		table = append(table, tableEntry{t, written})
+
+		tb := [3]byte{byte(t >> 16), byte(t >> 8), byte(t)}
+		n, err := bw.Write(tb[:])
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+
+		prev := int64(-1)
+		for _, id := range postings[t] {
+			newID := int64(remap[id])
+			m := binary.PutUvarint(buf[:], uint64(newID-prev))
+			prev = newID
+			n, err := bw.Write(buf[:m])
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+		m := binary.PutUvarint(buf[:], 0)
+		n, err = bw.Write(buf[:m])
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	tableOffset := written
+	for _, e := range table {
+		var rec [trigramRecordLen]byte
+		rec[0], rec[1], rec[2] = byte(e.t>>16), byte(e.t>>8), byte(e.t)
+		binary.BigEndian.PutUint64(rec[3:], uint64(e.offset))
+		n, err := bw.Write(rec[:])
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	var trailer [trailerLen]byte
+	binary.BigEndian.PutUint64(trailer[:8], uint64(namesOffset))
+	binary.BigEndian.PutUint64(trailer[8:16], uint64(tableOffset))
+	binary.BigEndian.PutUint64(trailer[16:24], uint64(len(table)))
+	copy(trailer[24:], fileMagic)
+	n, err = bw.Write(trailer[:])
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return written, err
+	}
+
+	// Only now, with the file fully written, apply the same compaction
+	// to idx itself, reclaiming the tombstoned postings' memory.
+	idx.Compact()
+
+	return written, nil
+}
+
+// ReadOnlyIndex is a trigram index backed by a file mapped into memory
+// with mmap. Posting lists are decoded lazily as QueryTrigrams needs
+// them, so corpora much larger than available RAM can still be queried
+// without loading every posting list into a Go map up front.
+type ReadOnlyIndex struct {
+	data        []byte
+	namesOffset int64
+	tableOffset int64
+	numTrigrams int
+	numDocs     int
+	names       []string // decoded once in parseHeaders; nil if the file has no names section
+}
+
+// Open mmaps the index file at path, written previously by WriteTo, and
+// returns a ReadOnlyIndex backed by it. Callers must call Close when
+// done to unmap the file.
+func Open(path string) (*ReadOnlyIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	ri := &ReadOnlyIndex{data: data}
+	if err := ri.parseHeaders(); err != nil {
+		syscall.Munmap(data)
+		return nil, err
+	}
+
+	return ri, nil
+}
+
+// Close unmaps the underlying file. The ReadOnlyIndex must not be used
+// afterwards.
+func (ri *ReadOnlyIndex) Close() error {
+	return syscall.Munmap(ri.data)
+}
+
+func (ri *ReadOnlyIndex) parseHeaders() error {
+	if len(ri.data) < len(fileMagic)+trailerLen {
+		return ErrCorrupt
+	}
+	if string(ri.data[:len(fileMagic)]) != fileMagic {
+		return ErrCorrupt
+	}
+
+	numDocs, n := binary.Uvarint(ri.data[len(fileMagic):])
+	if n <= 0 {
+		return ErrCorrupt
+	}
+	ri.numDocs = int(numDocs)
+
+	trailer := ri.data[len(ri.data)-trailerLen:]
+	if string(trailer[24:]) != fileMagic {
+		return ErrCorrupt
+	}
+	ri.namesOffset = int64(binary.BigEndian.Uint64(trailer[:8]))
+	ri.tableOffset = int64(binary.BigEndian.Uint64(trailer[8:16]))
+	ri.numTrigrams = int(binary.BigEndian.Uint64(trailer[16:24]))
+
+	if ri.namesOffset != 0 {
+		names, err := ri.decodeNames()
+		if err != nil {
+			return err
+		}
+		ri.names = names
+	}
+
+	return nil
+}
+
+// decodeNames decodes the names section written by WriteToNames, starting
+// at ri.namesOffset: a count, followed by that many (length, bytes) pairs.
+func (ri *ReadOnlyIndex) decodeNames() ([]string, error) {
+	p := ri.data[ri.namesOffset:]
+
+	count, n := binary.Uvarint(p)
+	if n <= 0 {
+		return nil, ErrCorrupt
+	}
+	p = p[n:]
+
+	names := make([]string, count)
+	for i := range names {
+		l, n := binary.Uvarint(p)
+		if n <= 0 || uint64(len(p)-n) < l {
+			return nil, ErrCorrupt
+		}
+		p = p[n:]
+		names[i] = string(p[:l])
+		p = p[l:]
+	}
+
+	return names, nil
+}
+
+// HasNames reports whether the index file has a names section, i.e. it
+// was written with WriteToNames rather than WriteTo.
+func (ri *ReadOnlyIndex) HasNames() bool {
+	return ri.namesOffset != 0
+}
+
+// Name returns the name (or metadata) recorded for id. It returns false
+// if the file has no names section or id is out of range.
+func (ri *ReadOnlyIndex) Name(id DocID) (string, bool) {
+	if ri.names == nil || int(id) >= len(ri.names) {
+		return "", false
+	}
+	return ri.names[int(id)], true
+}
+
+// NumDocs returns the total number of documents in the index.
+func (ri *ReadOnlyIndex) NumDocs() int {
+	return ri.numDocs
+}
+
+// tableEntry returns the trigram and posting list offset of the i'th
+// entry of the offset table.
+func (ri *ReadOnlyIndex) tableEntry(i int) (T, int64) {
+	rec := ri.data[ri.tableOffset+int64(i)*trigramRecordLen:]
+	t := T(uint32(rec[0])<<16 | uint32(rec[1])<<8 | uint32(rec[2]))
+	return t, int64(binary.BigEndian.Uint64(rec[3:trigramRecordLen]))
+}
+
+// findOffset binary-searches the offset table for t's posting list.
+func (ri *ReadOnlyIndex) findOffset(t T) (int64, bool) {
+	lo, hi := 0, ri.numTrigrams
+	for lo < hi {
+		mid := (lo + hi) / 2
+		rt, offset := ri.tableEntry(mid)
+		switch {
+		case rt == t:
+			return offset, true
+		case rt < t:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return 0, false
+}
+
+// decodePostings decodes the posting list stored at offset (which points
+// at its 3-byte trigram, as written by WriteTo).
+func (ri *ReadOnlyIndex) decodePostings(offset int64) []DocID {
+	p := ri.data[offset+3:]
+	var ids []DocID
+	prev := int64(-1)
+	for {
+		delta, n := binary.Uvarint(p)
+		p = p[n:]
+		if delta == 0 {
+			break
+		}
+		prev += int64(delta)
+		ids = append(ids, DocID(prev))
+	}
+	return ids
+}
+
+// postings returns the decoded posting list for t, and whether t is
+// present in the index.
+func (ri *ReadOnlyIndex) postings(t T) ([]DocID, bool) {
+	offset, ok := ri.findOffset(t)
+	if !ok {
+		return nil, false
+	}
+	return ri.decodePostings(offset), true
+}
+
+// Query returns a list of document IDs that match the trigrams in the
+// query s.
+func (ri *ReadOnlyIndex) Query(s string) []DocID {
+	return ri.QueryTrigrams(Extract(s, nil))
+}
+
+// QueryTrigrams returns a list of document IDs that match the trigram
+// set ts.
+func (ri *ReadOnlyIndex) QueryTrigrams(ts []T) []DocID {
+	if len(ts) == 0 {
+		ids := make([]DocID, ri.numDocs)
+		for i := range ids {
+			ids[i] = DocID(i)
+		}
+		return ids
+	}
+
+	lists := make([][]DocID, len(ts))
+	for i, t := range ts {
+		d, ok := ri.postings(t)
+		if !ok {
+			return nil
+		}
+		lists[i] = d
+	}
+
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+
+	docs := lists[0]
+	for _, d := range lists[1:] {
+		docs = intersect(make([]DocID, 0, len(docs)), docs, d)
+	}
+
+	return docs
+}
+
+// Load reads a file written by WriteTo and decodes it into a mutable
+// in-memory Index.
+func Load(path string) (Index, error) {
+	ri, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer ri.Close()
+
+	idx := make(Index, ri.numTrigrams+1)
+
+	allDocIDs := make([]DocID, ri.numDocs)
+	for i := range allDocIDs {
+		allDocIDs[i] = DocID(i)
+	}
+	idx[tAllDocIDs] = allDocIDs
+
+	for i := 0; i < ri.numTrigrams; i++ {
+		t, offset := ri.tableEntry(i)
+		idx[t] = ri.decodePostings(offset)
+	}
+
+	return idx, nil
+}
+
+// LoadNames reads the names section of a file written by WriteToNames,
+// returning nil if the file has none.
+func LoadNames(path string) ([]string, error) {
+	ri, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer ri.Close()
+
+	return ri.names, nil
+}
@@ -0,0 +1,149 @@
+package trigram
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+)
+
+func writeTemp(t *testing.T, write func(*os.File) (int64, error)) string {
+	t.Helper()
+
+	f, err := ioutil.TempFile("", "go-trigram-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := write(f); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	return f.Name()
+}
+
+func TestWriteToLoadRoundTrip(t *testing.T) {
+	idx := NewIndex([]string{"foo", "bar", "foobar"})
+
+	var buf bytes.Buffer
+	if _, err := idx.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeTemp(t, func(f *os.File) (int64, error) { n, err := f.Write(buf.Bytes()); return int64(n), err })
+	defer os.Remove(path)
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []string{"foo", "bar", "foobar", "oob"} {
+		want := idx.Query(q)
+		sort.Sort(docList(want))
+		got := loaded.Query(q)
+		sort.Sort(docList(got))
+		if !equalDocIDs(want, got) {
+			t.Errorf("Query(%q) = %v, want %v", q, got, want)
+		}
+	}
+}
+
+func TestWriteToNamesOpen(t *testing.T) {
+	idx := NewIndex([]string{"foo", "bar", "foobar"})
+	names := []string{"foo.txt", "bar.txt", "foobar.txt"}
+
+	path := writeTemp(t, func(f *os.File) (int64, error) { return idx.WriteToNames(f, names) })
+	defer os.Remove(path)
+
+	ri, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ri.Close()
+
+	if !ri.HasNames() {
+		t.Fatal("HasNames() = false, want true")
+	}
+
+	for id, want := range names {
+		got, ok := ri.Name(DocID(id))
+		if !ok || got != want {
+			t.Errorf("Name(%d) = %q, %v, want %q, true", id, got, ok, want)
+		}
+	}
+
+	if _, ok := ri.Name(DocID(len(names))); ok {
+		t.Error("Name() for an out-of-range DocID returned ok = true")
+	}
+}
+
+func TestWriteToWithoutNames(t *testing.T) {
+	idx := NewIndex([]string{"foo", "bar"})
+
+	path := writeTemp(t, func(f *os.File) (int64, error) { return idx.WriteTo(f) })
+	defer os.Remove(path)
+
+	ri, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ri.Close()
+
+	if ri.HasNames() {
+		t.Fatal("HasNames() = true for a file written by WriteTo")
+	}
+	if _, ok := ri.Name(0); ok {
+		t.Error("Name() returned ok = true on a file with no names section")
+	}
+}
+
+// limitedWriter fails once it has accepted n bytes, simulating a write
+// error partway through WriteToNames.
+type limitedWriter struct {
+	n int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.n <= 0 {
+		return 0, io.ErrShortWrite
+	}
+	if len(p) > w.n {
+		p = p[:w.n]
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestWriteToNamesLeavesIndexUntouchedOnFailure(t *testing.T) {
+	idx := NewIndex([]string{"xxx", "yyy", "zzz"})
+	idx.DeleteID(0)
+
+	before := idx.Query("yyy")
+
+	if _, err := idx.WriteToNames(&limitedWriter{n: 4}, nil); err == nil {
+		t.Fatal("WriteToNames with a failing writer returned nil error")
+	}
+
+	if _, ok := idx[tTombstones]; !ok {
+		t.Error("tombstone list was dropped after a failed WriteToNames")
+	}
+	if got := idx.Query("yyy"); !equalDocIDs(got, before) {
+		t.Errorf("Query(%q) = %v after failed WriteToNames, want unchanged %v", "yyy", got, before)
+	}
+}
+
+func equalDocIDs(a, b []DocID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package trigram
+
+import "sync"
+
+// MultiIndex is a set of Index shards queried together as if they were a
+// single index. Each shard keeps its own local DocIDs; MultiIndex
+// translates them into one global ID space by offsetting shard i's IDs
+// by the total number of documents in the shards before it. This lets an
+// index be built incrementally, one shard per batch of documents,
+// instead of requiring every document to live in a single Index map.
+type MultiIndex []Index
+
+// offsets returns, for each shard, the global DocID of its first
+// document.
+func (mi MultiIndex) offsets() []DocID {
+	offs := make([]DocID, len(mi))
+	var total DocID
+	for i, idx := range mi {
+		offs[i] = total
+		total += DocID(len(idx[tAllDocIDs]))
+	}
+	return offs
+}
+
+// NumDocs returns the total number of documents across all shards.
+func (mi MultiIndex) NumDocs() int {
+	var total int
+	for _, idx := range mi {
+		total += len(idx[tAllDocIDs])
+	}
+	return total
+}
+
+// Query returns a list of global document IDs that match the trigrams in
+// the query s.
+func (mi MultiIndex) Query(s string) []DocID {
+	return mi.QueryTrigrams(Extract(s, nil))
+}
+
+// QueryTrigrams returns a list of global document IDs that match the
+// trigram set ts. Shards are queried concurrently, one goroutine each.
+// Because shard i owns a disjoint range of the global ID space strictly
+// below shard i+1's range, the per-shard results can just be
+// concatenated in shard order to produce a single sorted result; no
+// merge step is needed.
+func (mi MultiIndex) QueryTrigrams(ts []T) []DocID {
+	offs := mi.offsets()
+	results := make([][]DocID, len(mi))
+
+	var wg sync.WaitGroup
+	wg.Add(len(mi))
+	for i, idx := range mi {
+		go func(i int, idx Index) {
+			defer wg.Done()
+			// Index.QueryTrigrams reorders its argument, so each
+			// goroutine gets its own copy of ts
+			local := idx.QueryTrigrams(append([]T(nil), ts...))
+			shifted := make([]DocID, len(local))
+			for j, id := range local {
+				shifted[j] = id + offs[i]
+			}
+			results[i] = shifted
+		}(i, idx)
+	}
+	wg.Wait()
+
+	var total int
+	for _, r := range results {
+		total += len(r)
+	}
+
+	ids := make([]DocID, 0, total)
+	for _, r := range results {
+		ids = append(ids, r...)
+	}
+
+	return ids
+}
+
+// Filter removes global document IDs from docs that don't contain the
+// specified trigrams. docs must be sorted in ascending order, as produced
+// by Query/QueryTrigrams, so each ID can be attributed to its owning
+// shard by its position relative to the shard offsets. Shards are
+// filtered concurrently, one goroutine each, mirroring QueryTrigrams.
+func (mi MultiIndex) Filter(docs []DocID, ts []T) []DocID {
+	if len(mi) == 0 {
+		return nil
+	}
+
+	offs := mi.offsets()
+	perShard := make([][]DocID, len(mi))
+
+	shard := 0
+	for _, d := range docs {
+		for shard+1 < len(offs) && d >= offs[shard+1] {
+			shard++
+		}
+		perShard[shard] = append(perShard[shard], d-offs[shard])
+	}
+
+	results := make([][]DocID, len(mi))
+
+	var wg sync.WaitGroup
+	wg.Add(len(mi))
+	for i, idx := range mi {
+		go func(i int, idx Index) {
+			defer wg.Done()
+			if len(perShard[i]) == 0 {
+				return
+			}
+			local := idx.Filter(perShard[i], ts)
+			shifted := make([]DocID, len(local))
+			for j, id := range local {
+				shifted[j] = id + offs[i]
+			}
+			results[i] = shifted
+		}(i, idx)
+	}
+	wg.Wait()
+
+	var total int
+	for _, r := range results {
+		total += len(r)
+	}
+
+	ids := make([]DocID, 0, total)
+	for _, r := range results {
+		ids = append(ids, r...)
+	}
+
+	return ids
+}
@@ -0,0 +1,51 @@
+package trigram
+
+import "testing"
+
+func TestMultiIndexQueryOffsetsShards(t *testing.T) {
+	mi := MultiIndex{
+		NewIndex([]string{"foo", "bar"}),
+		NewIndex([]string{"foobar", "quux"}),
+	}
+
+	if got, want := mi.NumDocs(), 4; got != want {
+		t.Fatalf("NumDocs() = %d, want %d", got, want)
+	}
+
+	got := mi.Query("foo")
+	if !equalDocIDs(sortedCopy(got), []DocID{0, 2}) {
+		t.Errorf("Query(%q) = %v, want [0 2]", "foo", got)
+	}
+}
+
+func TestMultiIndexFilterMatchesSingleShardIndex(t *testing.T) {
+	docs := []string{"foo", "bar", "foobar", "quux", "foobarbaz", "barfoo"}
+	want := NewIndex(docs)
+
+	mi := MultiIndex{
+		NewIndex(docs[:3]),
+		NewIndex(docs[3:]),
+	}
+
+	wantDocs := want.QueryTrigrams(Extract("foo", nil))
+	wantFiltered := want.Filter(wantDocs, Extract("bar", nil))
+
+	miDocs := mi.QueryTrigrams(Extract("foo", nil))
+	miFiltered := mi.Filter(miDocs, Extract("bar", nil))
+
+	if !equalDocIDs(sortedCopy(wantFiltered), sortedCopy(miFiltered)) {
+		t.Errorf("MultiIndex.Filter = %v, want %v", miFiltered, wantFiltered)
+	}
+}
+
+func TestMultiIndexFilterEmptyShard(t *testing.T) {
+	mi := MultiIndex{
+		NewIndex([]string{"foo"}),
+		NewIndex(nil),
+	}
+
+	got := mi.Filter(mi.Query("foo"), nil)
+	if !equalDocIDs(got, []DocID{0}) {
+		t.Errorf("Filter = %v, want [0]", got)
+	}
+}
@@ -0,0 +1,322 @@
+package trigram
+
+import (
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// maxSet bounds the size of the exact-string sets tracked while analyzing
+// a regexp. Once a subexpression could match more than maxSet distinct
+// short strings, analysis gives up on tracking it exactly and falls back
+// to a (still correct, just less precise) trigram query.
+const maxSet = 16
+
+// queryOp is the boolean operator of a query node.
+type queryOp int
+
+const (
+	qNone queryOp = iota // matches no document
+	qAll                 // matches every document (no constraint)
+	qAnd                 // all of trigram and sub must match
+	qOr                  // at least one of sub must match
+)
+
+// query is a boolean expression over required trigrams, built from a
+// parsed regular expression. Evaluating it against an Index produces a
+// candidate set of documents that might contain a match.
+type query struct {
+	op      queryOp
+	trigram []string // for qAnd leaves: trigrams that must all be present
+	sub     []*query // for qAnd/qOr: subqueries to combine
+}
+
+func andQuery(a, b *query) *query {
+	switch {
+	case a.op == qNone || b.op == qNone:
+		return &query{op: qNone}
+	case a.op == qAll:
+		return b
+	case b.op == qAll:
+		return a
+	}
+	return &query{op: qAnd, sub: []*query{a, b}}
+}
+
+func orQuery(a, b *query) *query {
+	switch {
+	case a.op == qAll || b.op == qAll:
+		return &query{op: qAll}
+	case a.op == qNone:
+		return b
+	case b.op == qNone:
+		return a
+	}
+	return &query{op: qOr, sub: []*query{a, b}}
+}
+
+// trigramsOfString returns a query requiring every trigram of s to be
+// present. Strings shorter than 3 bytes carry no trigram of their own,
+// so they're treated as unconstrained.
+func trigramsOfString(s string) *query {
+	ts := Extract(s, nil)
+	if len(ts) == 0 {
+		return &query{op: qAll}
+	}
+	trig := make([]string, len(ts))
+	for i, t := range ts {
+		trig[i] = t.String()
+	}
+	return &query{op: qAnd, trigram: trig}
+}
+
+// stringSetQuery returns a query requiring at least one of strs to be
+// present in full (i.e. the OR, across strs, of each string's AND of
+// trigrams).
+func stringSetQuery(strs []string) *query {
+	q := &query{op: qNone}
+	for _, s := range strs {
+		if len(s) < 3 {
+			// too short to contribute a trigram; can't constrain on it
+			return &query{op: qAll}
+		}
+		q = orQuery(q, trigramsOfString(s))
+	}
+	return q
+}
+
+// info is the result of analyzing one node of a parsed regexp: either a
+// small set of strings it matches exactly, or (once that set would grow
+// too large, or the node isn't string-like) a query already reduced from
+// whatever exact information was available before giving up.
+type info struct {
+	exact []string // nil once this subexpression is no longer exactly tracked
+	q     *query
+}
+
+func allInfo() info  { return info{q: &query{op: qAll}} }
+func noneInfo() info { return info{q: &query{op: qNone}} }
+
+func (in info) flush() *query {
+	if in.exact != nil {
+		return stringSetQuery(in.exact)
+	}
+	return in.q
+}
+
+func cross(a, b []string) []string {
+	out := make([]string, 0, len(a)*len(b))
+	for _, x := range a {
+		for _, y := range b {
+			out = append(out, x+y)
+		}
+	}
+	return out
+}
+
+// analyze walks a parsed, simplified regexp and derives the trigram
+// query that any document matching it must satisfy. Constructs that
+// don't pin down a bounded set of literal strings (`.`, unbounded
+// repeats, anchors, ...) are expanded conservatively: they widen the
+// query rather than risk excluding a real match.
+func analyze(re *syntax.Regexp) info {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return noneInfo()
+
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine,
+		syntax.OpBeginText, syntax.OpEndText, syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return info{exact: []string{""}}
+
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			// re.Rune is one case of a case-insensitive literal (the
+			// simplifier folds constructs like "[jJ]" down to this);
+			// treating it as the only case would exclude real matches
+			// spelled with the other case, so don't narrow on it at all.
+			return allInfo()
+		}
+		return info{exact: []string{string(re.Rune)}}
+
+	case syntax.OpCharClass:
+		var strs []string
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			lo, hi := re.Rune[i], re.Rune[i+1]
+			if int(hi-lo)+1+len(strs) > maxSet {
+				return allInfo()
+			}
+			for r := lo; r <= hi; r++ {
+				strs = append(strs, string(r))
+			}
+		}
+		return info{exact: strs}
+
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return allInfo()
+
+	case syntax.OpCapture:
+		return analyze(re.Sub[0])
+
+	case syntax.OpStar, syntax.OpQuest:
+		// zero occurrences is a valid match, so nothing is required
+		return allInfo()
+
+	case syntax.OpPlus:
+		return info{q: analyze(re.Sub[0]).flush()}
+
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return info{q: analyze(re.Sub[0]).flush()}
+		}
+		return allInfo()
+
+	case syntax.OpConcat:
+		cur := info{exact: []string{""}}
+		q := &query{op: qAll}
+		for _, sub := range re.Sub {
+			in := analyze(sub)
+			if cur.exact != nil && in.exact != nil && len(cur.exact)*len(in.exact) <= maxSet {
+				cur.exact = cross(cur.exact, in.exact)
+				continue
+			}
+			q = andQuery(q, cur.flush())
+			cur = in
+		}
+		q = andQuery(q, cur.flush())
+		return info{q: q}
+
+	case syntax.OpAlternate:
+		var exact []string
+		exactOK := true
+		q := &query{op: qNone}
+		for _, sub := range re.Sub {
+			in := analyze(sub)
+			if exactOK && in.exact != nil && len(exact)+len(in.exact) <= maxSet {
+				exact = append(exact, in.exact...)
+				continue
+			}
+			if exactOK {
+				// the set we were building is being abandoned; fold it
+				// into q before moving on to query-only combination
+				q = orQuery(q, stringSetQuery(exact))
+				exactOK = false
+			}
+			q = orQuery(q, in.flush())
+		}
+		if exactOK {
+			return info{exact: exact}
+		}
+		return info{q: q}
+
+	default:
+		return allInfo()
+	}
+}
+
+func trigramFromString(s string) T {
+	return T(uint32(s[0])<<16 | uint32(s[1])<<8 | uint32(s[2]))
+}
+
+// evalQuery evaluates q against idx, returning the candidate document
+// IDs that satisfy it.
+func (idx Index) evalQuery(q *query) []DocID {
+	switch q.op {
+	case qNone:
+		return nil
+	case qAll:
+		return idx.filterTombstones(idx[tAllDocIDs])
+	case qAnd:
+		var ts []T
+		for _, s := range q.trigram {
+			ts = append(ts, trigramFromString(s))
+		}
+		ids := idx.QueryTrigrams(ts)
+		for _, sub := range q.sub {
+			if ids == nil {
+				return nil
+			}
+			ids = intersect(make([]DocID, 0, len(ids)), ids, idx.evalQuery(sub))
+		}
+		return ids
+	case qOr:
+		seen := make(map[DocID]bool)
+		var all []DocID
+		for _, sub := range q.sub {
+			for _, id := range idx.evalQuery(sub) {
+				if !seen[id] {
+					seen[id] = true
+					all = append(all, id)
+				}
+			}
+		}
+		sort.Sort(docList(all))
+		return all
+	}
+	return nil
+}
+
+// QueryRegexp returns the document IDs that may contain a match for the
+// regular expression expr. The result is a candidate set, in the
+// codesearch/csearch style: it is guaranteed to contain every matching
+// document, but callers must still run expr against a candidate's text
+// to confirm the match, since patterns with little literal structure
+// (".*", anchors, short alternations, ...) can only be narrowed so far.
+func (idx Index) QueryRegexp(expr string) ([]DocID, error) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	q := analyze(re.Simplify()).flush()
+	return idx.evalQuery(q), nil
+}
+
+// QueryGlob returns the document IDs that may contain a match for the
+// shell glob pattern. It translates the glob to an equivalent regular
+// expression and delegates to QueryRegexp, so the same caveats about the
+// result being a candidate set apply.
+func (idx Index) QueryGlob(pattern string) ([]DocID, error) {
+	return idx.QueryRegexp(globToRegexp(pattern))
+}
+
+// globToRegexp translates a shell glob pattern (`*`, `?`, `[...]`) into
+// an equivalent regular expression, escaping everything else that's a
+// regexp metacharacter.
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteByte('.')
+		case '[':
+			j := i + 1
+			if j < len(pattern) && pattern[j] == '!' {
+				j++
+			}
+			if j < len(pattern) && pattern[j] == ']' {
+				j++
+			}
+			for j < len(pattern) && pattern[j] != ']' {
+				j++
+			}
+			if j >= len(pattern) {
+				b.WriteString(`\[`)
+				continue
+			}
+			cls := pattern[i : j+1]
+			if cls[1] == '!' {
+				cls = "[^" + cls[2:]
+			}
+			b.WriteString(cls)
+			i = j
+		case '.', '+', '(', ')', '|', '^', '$', '{', '}', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
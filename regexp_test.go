@@ -0,0 +1,94 @@
+package trigram
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestQueryRegexpCandidatesContainAllRealMatches(t *testing.T) {
+	docs := []string{
+		"the quick brown fox",
+		"jumps over the lazy dog",
+		"hello world",
+		"foobarbaz",
+	}
+	idx := NewIndex(docs)
+
+	for _, expr := range []string{"qu.ck", "fox|dog", "^hello", "foo.*baz", "[jJ]umps"} {
+		re := regexp.MustCompile(expr)
+		candidates := make(map[DocID]bool)
+		got, err := idx.QueryRegexp(expr)
+		if err != nil {
+			t.Fatalf("QueryRegexp(%q): %v", expr, err)
+		}
+		for _, id := range got {
+			candidates[id] = true
+		}
+
+		for i, d := range docs {
+			if re.MatchString(d) && !candidates[DocID(i)] {
+				t.Errorf("QueryRegexp(%q) = %v, missing real match doc %d (%q)", expr, got, i, d)
+			}
+		}
+	}
+}
+
+func TestQueryGlob(t *testing.T) {
+	docs := []string{"report.txt", "report.csv", "image.png", "archive.tar.gz"}
+	idx := NewIndex(docs)
+
+	got, err := idx.QueryGlob("*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	candidates := make(map[DocID]bool)
+	for _, id := range got {
+		candidates[id] = true
+	}
+	if !candidates[0] {
+		t.Errorf("QueryGlob(%q) = %v, missing real match doc 0 (%q)", "*.txt", got, docs[0])
+	}
+}
+
+func TestQueryRegexpOmitsDeletedDocs(t *testing.T) {
+	docs := []string{"the quick brown fox", "hello world"}
+	idx := NewIndex(docs)
+	idx.DeleteID(0)
+
+	// ".*" bottoms out at qAll, the one path that doesn't flow through
+	// QueryTrigrams/Filter's usual tombstone filtering.
+	got, err := idx.QueryRegexp(".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range got {
+		if id == 0 {
+			t.Errorf("QueryRegexp(%q) = %v, still contains deleted doc 0", ".*", got)
+		}
+	}
+
+	got, err = idx.QueryGlob("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range got {
+		if id == 0 {
+			t.Errorf("QueryGlob(%q) = %v, still contains deleted doc 0", "*", got)
+		}
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	cases := map[string]string{
+		"*.txt":    `.*\.txt`,
+		"file?.go": `file.\.go`,
+		"[abc].go": `[abc]\.go`,
+		"[!a].go":  `[^a]\.go`,
+	}
+	for glob, want := range cases {
+		if got := globToRegexp(glob); got != want {
+			t.Errorf("globToRegexp(%q) = %q, want %q", glob, got, want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package trigram
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// containerBits is the number of low bits of a DocID that fall within
+// one bitmap container; containers are keyed by the remaining high
+// bits, so each one covers a contiguous run of containerSize DocIDs.
+const containerBits = 16
+const containerSize = 1 << containerBits
+
+// Posting lists denser than denseThresholdCount documents, or denser
+// than denseThresholdFrac of the whole corpus, are represented as a
+// bitmap instead of a sorted DocID slice. Common English trigrams like
+// " th" or "the" routinely land in more than half of a text corpus,
+// where a bitmap's O(containers) intersect beats a slice merge's
+// O(n+m), and its memory footprint is a large improvement too.
+const (
+	denseThresholdCount = 4096
+	denseThresholdFrac  = 0.05
+)
+
+// postingList is the internal representation of one trigram's document
+// set, used by Filter to pick the cheapest way to intersect two lists.
+// sliceList backs sparse trigrams, which pay no overhead over a plain
+// []DocID; bitmapList backs dense ones.
+type postingList interface {
+	Contains(id DocID) bool
+	Iterate(f func(DocID))
+	Intersect(other postingList) postingList
+	Len() int
+}
+
+// newPostingList picks a postingList representation for ids, given that
+// the corpus has numDocs documents in total.
+func newPostingList(ids []DocID, numDocs int) postingList {
+	if isDense(len(ids), numDocs) {
+		return newBitmapList(ids)
+	}
+	return sliceList(ids)
+}
+
+func isDense(n, numDocs int) bool {
+	if n > denseThresholdCount {
+		return true
+	}
+	return numDocs > 0 && float64(n) > denseThresholdFrac*float64(numDocs)
+}
+
+// docIDs drains pl into a sorted []DocID.
+func docIDs(pl postingList) []DocID {
+	ids := make([]DocID, 0, pl.Len())
+	pl.Iterate(func(id DocID) { ids = append(ids, id) })
+	return ids
+}
+
+// sliceList is a sparse posting list: a plain sorted slice of DocIDs.
+type sliceList []DocID
+
+func (s sliceList) Len() int { return len(s) }
+
+func (s sliceList) Contains(id DocID) bool {
+	i := sort.Search(len(s), func(i int) bool { return s[i] >= id })
+	return i < len(s) && s[i] == id
+}
+
+func (s sliceList) Iterate(f func(DocID)) {
+	for _, id := range s {
+		f(id)
+	}
+}
+
+func (s sliceList) Intersect(other postingList) postingList {
+	if o, ok := other.(sliceList); ok {
+		return sliceList(intersect(make([]DocID, 0, len(s)), []DocID(s), []DocID(o)))
+	}
+
+	var out sliceList
+	for _, id := range s {
+		if other.Contains(id) {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// bitmapContainer holds the membership bits for one contiguous run of
+// containerSize DocIDs.
+type bitmapContainer struct {
+	words [containerSize / 64]uint64
+}
+
+// bitmapList is a dense posting list: a sparse map of bitmapContainers,
+// keyed by the high bits of the DocIDs it contains. This is a
+// deliberately simplified roaring bitmap - a single bitmap container per
+// chunk, no run-length or array containers - which is enough to make
+// intersecting dense trigrams cheap without the complexity of the full
+// roaring format.
+type bitmapList struct {
+	containers map[uint32]*bitmapContainer
+	n          int
+}
+
+func newBitmapList(ids []DocID) *bitmapList {
+	bl := &bitmapList{containers: make(map[uint32]*bitmapContainer)}
+	for _, id := range ids {
+		bl.add(id)
+	}
+	return bl
+}
+
+func (bl *bitmapList) add(id DocID) {
+	hi := uint32(id) >> containerBits
+	lo := uint32(id) & (containerSize - 1)
+
+	c := bl.containers[hi]
+	if c == nil {
+		c = &bitmapContainer{}
+		bl.containers[hi] = c
+	}
+
+	word, bit := lo/64, lo%64
+	if c.words[word]&(1<<bit) == 0 {
+		c.words[word] |= 1 << bit
+		bl.n++
+	}
+}
+
+func (bl *bitmapList) Len() int { return bl.n }
+
+func (bl *bitmapList) Contains(id DocID) bool {
+	c, ok := bl.containers[uint32(id)>>containerBits]
+	if !ok {
+		return false
+	}
+	lo := uint32(id) & (containerSize - 1)
+	return c.words[lo/64]&(1<<(lo%64)) != 0
+}
+
+func (bl *bitmapList) Iterate(f func(DocID)) {
+	his := make([]uint32, 0, len(bl.containers))
+	for hi := range bl.containers {
+		his = append(his, hi)
+	}
+	sort.Slice(his, func(i, j int) bool { return his[i] < his[j] })
+
+	for _, hi := range his {
+		c := bl.containers[hi]
+		for w, word := range c.words {
+			for word != 0 {
+				b := bits.TrailingZeros64(word)
+				f(DocID(hi)<<containerBits | DocID(w*64+b))
+				word &= word - 1
+			}
+		}
+	}
+}
+
+func (bl *bitmapList) Intersect(other postingList) postingList {
+	o, ok := other.(*bitmapList)
+	if !ok {
+		var out sliceList
+		other.Iterate(func(id DocID) {
+			if bl.Contains(id) {
+				out = append(out, id)
+			}
+		})
+		return out
+	}
+
+	result := &bitmapList{containers: make(map[uint32]*bitmapContainer)}
+	for hi, c := range bl.containers {
+		oc, ok := o.containers[hi]
+		if !ok {
+			continue
+		}
+
+		nc := &bitmapContainer{}
+		var n int
+		for w := range nc.words {
+			nc.words[w] = c.words[w] & oc.words[w]
+			n += bits.OnesCount64(nc.words[w])
+		}
+		if n > 0 {
+			result.containers[hi] = nc
+			result.n += n
+		}
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+package trigram
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestNewPostingListPicksRepresentationByDensity(t *testing.T) {
+	sparse := newPostingList([]DocID{1, 2, 3}, 1000)
+	if _, ok := sparse.(sliceList); !ok {
+		t.Errorf("newPostingList with 3/1000 docs = %T, want sliceList", sparse)
+	}
+
+	dense := newPostingList(rangeIDs(600), 1000)
+	if _, ok := dense.(*bitmapList); !ok {
+		t.Errorf("newPostingList with 600/1000 docs = %T, want *bitmapList", dense)
+	}
+}
+
+func rangeIDs(n int) []DocID {
+	ids := make([]DocID, n)
+	for i := range ids {
+		ids[i] = DocID(i)
+	}
+	return ids
+}
+
+func TestBitmapListRoundTrip(t *testing.T) {
+	ids := []DocID{0, 1, 65, 66, 1 << 16, (1 << 16) + 5, (3 << 16) + 40000}
+	bl := newBitmapList(ids)
+
+	if bl.Len() != len(ids) {
+		t.Fatalf("Len() = %d, want %d", bl.Len(), len(ids))
+	}
+	for _, id := range ids {
+		if !bl.Contains(id) {
+			t.Errorf("Contains(%d) = false, want true", id)
+		}
+	}
+	if bl.Contains(42) {
+		t.Error("Contains(42) = true, want false")
+	}
+
+	got := docIDs(bl)
+	want := append([]DocID(nil), ids...)
+	sort.Sort(docList(want))
+	if !equalDocIDs(got, want) {
+		t.Errorf("docIDs(bl) = %v, want %v", got, want)
+	}
+}
+
+func TestBitmapListIntersect(t *testing.T) {
+	a := newBitmapList([]DocID{1, 2, 3, 1 << 16, (2 << 16) + 7})
+	b := newBitmapList([]DocID{2, 3, 4, 1 << 16})
+
+	got := docIDs(a.Intersect(b))
+	want := []DocID{2, 3, 1 << 16}
+	if !equalDocIDs(got, want) {
+		t.Errorf("bitmapList.Intersect = %v, want %v", got, want)
+	}
+}
+
+func TestBitmapAndSliceIntersectAgree(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	var a, b []DocID
+	seen := make(map[DocID]bool)
+	for i := 0; i < 500; i++ {
+		id := DocID(r.Intn(5000))
+		if !seen[id] {
+			seen[id] = true
+			a = append(a, id)
+		}
+	}
+	seen = make(map[DocID]bool)
+	for i := 0; i < 500; i++ {
+		id := DocID(r.Intn(5000))
+		if !seen[id] {
+			seen[id] = true
+			b = append(b, id)
+		}
+	}
+	sort.Sort(docList(a))
+	sort.Sort(docList(b))
+
+	want := intersect(nil, a, b)
+
+	bmA := newBitmapList(a)
+	bmB := newBitmapList(b)
+	got := docIDs(bmA.Intersect(bmB))
+
+	if !equalDocIDs(got, want) {
+		t.Errorf("bitmap intersect disagrees with slice intersect: got %d ids, want %d", len(got), len(want))
+	}
+}
@@ -0,0 +1,105 @@
+package trigram
+
+import "sort"
+
+// tTombstones is a special (and invalid) trigram that holds the sorted
+// IDs of documents deleted via DeleteID but not yet physically removed
+// by Compact.
+const tTombstones T = 0xFFFFFFFE
+
+// DeleteID marks id as deleted. It's O(log n) plus an insert into the
+// tombstone list, unlike Delete, which re-extracts s's trigrams and
+// splices id out of each one's posting list - and it doesn't
+// require the caller to have kept the original document text around.
+// QueryTrigrams and Filter skip tombstoned IDs immediately; the postings
+// themselves are only physically rewritten once Compact is called,
+// amortizing that cost across many deletes the way production search
+// engines (bleve's scorch, codesearch) do.
+func (idx Index) DeleteID(id DocID) {
+	tomb := idx[tTombstones]
+	i := sort.Search(len(tomb), func(i int) bool { return tomb[i] >= id })
+	if i < len(tomb) && tomb[i] == id {
+		return
+	}
+
+	tomb = append(tomb, 0)
+	copy(tomb[i+1:], tomb[i:])
+	tomb[i] = id
+	idx[tTombstones] = tomb
+}
+
+// isTombstoned reports whether id has been deleted via DeleteID and not
+// yet compacted away.
+func (idx Index) isTombstoned(id DocID) bool {
+	tomb := idx[tTombstones]
+	i := sort.Search(len(tomb), func(i int) bool { return tomb[i] >= id })
+	return i < len(tomb) && tomb[i] == id
+}
+
+// filterTombstones returns ids with any tombstoned DocIDs removed,
+// leaving ids itself untouched.
+func (idx Index) filterTombstones(ids []DocID) []DocID {
+	tomb := idx[tTombstones]
+	if len(tomb) == 0 {
+		return ids
+	}
+	return removeSorted(append([]DocID(nil), ids...), tomb)
+}
+
+// removeSorted returns ids, in place, with every entry present in remove
+// dropped. Both slices must be sorted.
+func removeSorted(ids, remove []DocID) []DocID {
+	if len(remove) == 0 {
+		return ids
+	}
+
+	out := ids[:0]
+	ri := 0
+	for _, id := range ids {
+		for ri < len(remove) && remove[ri] < id {
+			ri++
+		}
+		if ri < len(remove) && remove[ri] == id {
+			continue
+		}
+		out = append(out, id)
+	}
+	return out
+}
+
+// Compact rewrites every posting list to physically drop documents
+// deleted via DeleteID, then clears the tombstone list. Call it
+// periodically to reclaim the memory tombstoned postings still occupy;
+// until then their cost is paid at query time instead, via
+// filterTombstones.
+func (idx Index) Compact() {
+	tomb := idx[tTombstones]
+	if len(tomb) == 0 {
+		return
+	}
+
+	for t, ids := range idx {
+		if t == tTombstones {
+			continue
+		}
+
+		if ids == nil && t != tAllDocIDs {
+			// pruned via Prune: nil means "unconstrained", so keep it
+			continue
+		}
+
+		ids = removeSorted(ids, tomb)
+		if len(ids) == 0 && t != tAllDocIDs {
+			// Match Delete's invariant: a trigram with no remaining
+			// documents is removed outright rather than left behind as
+			// an empty entry, which QueryTrigrams and Filter would
+			// otherwise mistake for a trigram pruned via Prune (whose
+			// nil value means "unconstrained", not "matches nothing").
+			delete(idx, t)
+			continue
+		}
+		idx[t] = ids
+	}
+
+	delete(idx, tTombstones)
+}
@@ -0,0 +1,94 @@
+package trigram
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDeleteIDHidesDocImmediately(t *testing.T) {
+	idx := NewIndex([]string{"xxx", "yyy", "zzz"})
+	idx.DeleteID(0)
+
+	if got := idx.Query("xxx"); len(got) != 0 {
+		t.Errorf("Query(%q) = %v right after DeleteID, want none", "xxx", got)
+	}
+	if got := idx.Query("yyy"); !equalDocIDs(got, []DocID{1}) {
+		t.Errorf("Query(%q) = %v, want [1]", "yyy", got)
+	}
+}
+
+func TestDeleteIDIsIdempotent(t *testing.T) {
+	idx := NewIndex([]string{"xxx", "yyy"})
+	idx.DeleteID(0)
+	idx.DeleteID(0)
+
+	if got := idx[tTombstones]; len(got) != 1 {
+		t.Errorf("tombstones = %v, want exactly one entry", got)
+	}
+}
+
+func TestCompactDropsEmptyTrigramsNotPrunedOnes(t *testing.T) {
+	idx := NewIndex([]string{"xxx", "yyy", "yyy"})
+	idx.Prune(0.5) // prunes "yyy" (in 2 of 3 documents) but not "xxx"
+	idx.DeleteID(0)
+	idx.Compact()
+
+	if _, ok := idx[tTombstones]; ok {
+		t.Error("tombstone list still present after Compact")
+	}
+
+	// "xxx" had no documents left after compaction: Filter must treat its
+	// now-absent entry as "matches nothing", the same as Delete would,
+	// not as the "unconstrained" nil left by Prune.
+	if got := idx.Query("xxx"); len(got) != 0 {
+		t.Errorf("Query(%q) = %v after Compact removed its only document, want none", "xxx", got)
+	}
+
+	// "yyy" was pruned, so it stays unconstrained after Compact.
+	if got := idx.Query("yyy"); !equalDocIDs(got, []DocID{1, 2}) {
+		t.Errorf("Query(%q) = %v after Compact, want [1 2]", "yyy", got)
+	}
+}
+
+func TestCompactPreservesSurvivingIDs(t *testing.T) {
+	// Compact rewrites postings but, unlike WriteToNames's on-disk form,
+	// never renumbers surviving DocIDs: they remain valid identifiers
+	// into whatever external store (names, file contents, ...) the
+	// caller keyed by the original DocID.
+	idx := NewIndex([]string{"foo", "bar", "foobar", "baz"})
+	idx.DeleteID(1) // "bar"
+
+	before := idx.Query("baz")
+	idx.Compact()
+	after := idx.Query("baz")
+
+	if !equalDocIDs(before, []DocID{3}) || !equalDocIDs(after, []DocID{3}) {
+		t.Errorf("Query(%q) = %v before / %v after Compact, want [3] / [3]", "baz", before, after)
+	}
+}
+
+func TestWriteToPersistsDeletes(t *testing.T) {
+	idx := NewIndex([]string{"xxx", "yyy", "zzz"})
+	idx.DeleteID(0)
+
+	path := writeTemp(t, func(f *os.File) (int64, error) { return idx.WriteTo(f) })
+	defer os.Remove(path)
+
+	ri, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ri.Close()
+
+	if got := ri.Query("xxx"); len(got) != 0 {
+		t.Errorf("Query(%q) = %v after deleting its only match, want none", "xxx", got)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Query("xxx"); len(got) != 0 {
+		t.Errorf("Load().Query(%q) = %v after deleting its only match, want none", "xxx", got)
+	}
+}
@@ -54,34 +54,6 @@ func appendIfUnique(t []T, n T) []T {
 	return append(t, n)
 }
 
-// NewIndex returns an index for the strings in docs
-func NewIndex(docs []string) Index {
-
-	idx := make(Index)
-
-	var allDocIDs []DocID
-
-	var trigrams []T
-
-	for id, d := range docs {
-		ts := ExtractAll(d, trigrams)
-		docid := DocID(id)
-		allDocIDs = append(allDocIDs, docid)
-		for _, t := range ts {
-			idxt := idx[t]
-			l := len(idxt)
-			if l == 0 || idxt[l-1] != docid {
-				idx[t] = append(idxt, docid)
-			}
-		}
-		trigrams = trigrams[:0]
-	}
-
-	idx[tAllDocIDs] = allDocIDs
-
-	return idx
-}
-
 // Add adds a new string to the search index
 func (idx Index) Add(s string) DocID {
 	id := DocID(len(idx[tAllDocIDs]))
@@ -163,7 +135,7 @@ func (idx Index) Prune(pct float64) int {
 	var pruned int
 
 	for k, v := range idx {
-		if k != tAllDocIDs && len(v) > maxDocs {
+		if k != tAllDocIDs && k != tTombstones && len(v) > maxDocs {
 			pruned++
 			idx[k] = nil
 		}
@@ -194,7 +166,7 @@ func (tf tfList) Less(i, j int) bool { return tf.freq[i] < tf.freq[j] }
 func (idx Index) QueryTrigrams(ts []T) []DocID {
 
 	if len(ts) == 0 {
-		return idx[tAllDocIDs]
+		return idx.filterTombstones(idx[tAllDocIDs])
 	}
 
 	var freq []int
@@ -210,19 +182,29 @@ func (idx Index) QueryTrigrams(ts []T) []DocID {
 	sort.Sort(tfList{ts, freq})
 
 	var nonzero int
-	for freq[nonzero] == 0 {
+	for nonzero < len(freq) && freq[nonzero] == 0 {
 		nonzero++
 	}
 
+	// Every trigram's posting list was empty: either all of them were
+	// pruned (Filter treats a pruned, nil list as unconstrained) or one
+	// genuinely occurs in no document (Filter intersecting against its
+	// empty list correctly yields no matches either way), so anchor on
+	// the full document set and let Filter sort out which case applies.
+	if nonzero == len(freq) {
+		return idx.filterTombstones(idx.Filter(idx[tAllDocIDs], ts))
+	}
+
 	ids := idx.Filter(idx[ts[nonzero]], ts[nonzero+1:])
 
-	return ids
+	return idx.filterTombstones(ids)
 }
 
 // Filter removes documents that don't contain the specified trigrams
 func (idx Index) Filter(docs []DocID, ts []T) []DocID {
 
-	result := make([]DocID, len(docs))
+	numDocs := len(idx[tAllDocIDs])
+	result := newPostingList(idx.filterTombstones(docs), numDocs)
 
 	for _, t := range ts {
 		d, ok := idx[t]
@@ -236,11 +218,10 @@ func (idx Index) Filter(docs []DocID, ts []T) []DocID {
 			continue
 		}
 
-		result = intersect(result[:0], docs, d)
-		docs = result
+		result = result.Intersect(newPostingList(idx.filterTombstones(d), numDocs))
 	}
 
-	return docs
+	return docIDs(result)
 }
 
 func intersect(result, a, b []DocID) []DocID {